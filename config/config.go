@@ -0,0 +1,97 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config contains the configuration used by stenographer, read
+// from a config file on disk at startup.
+package config
+
+import "time"
+
+// Config is the top-level configuration for a stenographer instance.
+type Config struct {
+	Threads   []ThreadConfig
+	Interface string
+	Flags     []string
+	Port      int
+	CertPath  string
+	KeyPath   string
+}
+
+// StorageTier describes a single tier of storage backing a thread, eg. a
+// fast local NVMe drive, a warm SATA array, or a cold archive mount.  A
+// ThreadConfig with multiple tiers causes packet/index files to migrate
+// from one tier to the next as disk space on the earlier tier runs low,
+// rather than being deleted outright.
+type StorageTier struct {
+	// PacketsDirectory and IndexDirectory are the directories backing this
+	// tier.  They behave exactly like ThreadConfig's fields of the same
+	// name, but scoped to this tier alone.
+	PacketsDirectory string
+	IndexDirectory   string
+	// DiskFreePercentage is the minimum percentage of free disk space this
+	// tier should maintain.  Once free space on this tier's filesystem
+	// drops below this value, the oldest packet+index pair on this tier is
+	// migrated to the next tier (or deleted, if this is the last tier).
+	DiskFreePercentage int
+	// MaxAge, if nonzero, evicts files from this tier once they're older
+	// than this duration, even if disk space isn't under pressure.
+	MaxAge time.Duration
+}
+
+// RetentionRule protects files that still contain packets matching Query
+// from eviction until MinAge has passed, so that, eg., DNS/SYN traffic can
+// be kept for 30 days while bulk video is evicted after 24 hours.  Priority
+// ranks which protected traffic should be evicted first if several
+// candidate files are all past eviction-worthiness: lower-priority files go
+// first.
+type RetentionRule struct {
+	Query    string
+	MinAge   time.Duration
+	Priority int
+}
+
+// ThreadConfig contains the information needed by a single stenotype
+// thread, detailing where it should read/write state to/from.
+type ThreadConfig struct {
+	// PacketsDirectory and IndexDirectory are retained for backwards
+	// compatibility with single-tier configs:  if Tiers is empty, they're
+	// used to synthesize a single StorageTier.
+	PacketsDirectory   string
+	IndexDirectory     string
+	DiskFreePercentage int
+	// Tiers, if set, takes precedence over the legacy fields above and
+	// describes an ordered list of storage tiers, from fastest/most
+	// expensive to slowest/cheapest.  Files are evicted from Tiers[0]
+	// toward Tiers[len(Tiers)-1], and only files evicted from the last
+	// tier are actually deleted.
+	Tiers []StorageTier
+	// RetentionRules, if set, makes eviction policy-aware instead of pure
+	// oldest-first: a candidate file protected by a still-active rule is
+	// skipped in favor of the next, lowest-priority, unprotected candidate.
+	RetentionRules []RetentionRule
+}
+
+// StorageTiers returns the effective list of storage tiers for this thread,
+// synthesizing a single tier from the legacy Packets/IndexDirectory fields
+// when Tiers isn't set.
+func (tc ThreadConfig) StorageTiers() []StorageTier {
+	if len(tc.Tiers) > 0 {
+		return tc.Tiers
+	}
+	return []StorageTier{{
+		PacketsDirectory:   tc.PacketsDirectory,
+		IndexDirectory:     tc.IndexDirectory,
+		DiskFreePercentage: tc.DiskFreePercentage,
+	}}
+}
@@ -0,0 +1,267 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/stenographer/base"
+	"github.com/google/stenographer/httputil"
+)
+
+// DeviceID returns a stable identifier for the filesystem backing this
+// thread's tier-0 (hot) packet directory: the filesystem's UUID plus the
+// path relative to its mountpoint.  Threads whose DeviceIDs carry the same
+// UUID live on the same underlying disk, and so should coordinate cleanup
+// rather than each independently racing to free space on it; see
+// groupThreadsByDevice.
+//
+// This borrows the volume-identity trick used by Arvados keepstore, which
+// derives a stable DeviceID from a volume's filesystem UUID via findmnt.
+func (t *Thread) DeviceID() (string, error) {
+	path, err := filepath.Abs(t.tiers[0].conf.PacketsDirectory)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %q: %v", t.tiers[0].conf.PacketsDirectory, err)
+	}
+	uuid, err := findmnt(path, "UUID")
+	if err != nil {
+		return "", err
+	}
+	mount, err := findmnt(path, "TARGET")
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(mount, path)
+	if err != nil {
+		return "", fmt.Errorf("could not compute %q relative to mountpoint %q: %v", path, mount, err)
+	}
+	return uuid + ":" + rel, nil
+}
+
+// findmnt shells out to findmnt(8) to read a single column of info about
+// the filesystem mounted at (or above) path.
+func findmnt(path, column string) (string, error) {
+	out, err := exec.Command("findmnt", "--noheadings", "--output", column, "--target", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("findmnt --output %s --target %q failed: %v", column, path, err)
+	}
+	val := strings.TrimSpace(string(out))
+	if val == "" {
+		return "", fmt.Errorf("findmnt --output %s --target %q returned nothing", column, path)
+	}
+	return val, nil
+}
+
+// DiskGroup coordinates tier-0 cleanup across every Thread that shares its
+// underlying filesystem (as determined by DeviceID), so a disk-space crunch
+// triggers one serialized cleanup pass that evicts the globally oldest
+// lowest-priority file across the whole group, rather than each thread's SyncFiles call
+// independently racing to delete its own.
+type DiskGroup struct {
+	deviceID string
+	mu       sync.Mutex // serializes cleanup across the group's threads
+	threads  []*Thread
+}
+
+// groupThreadsByDevice partitions threads into DiskGroups by the filesystem
+// UUID part of their DeviceID, so threads using different directories on
+// the same filesystem share a group, and assigns each thread's group field.  A thread whose DeviceID can't be
+// determined (eg. findmnt isn't installed) gets its own private, single
+// member group, so cleanup still works, just without cross-thread
+// coordination.
+func groupThreadsByDevice(threads []*Thread) []*DiskGroup {
+	ids := make([]string, len(threads))
+	for i, t := range threads {
+		id, err := t.DeviceID()
+		if err != nil {
+			log.Printf("Thread %v could not determine its disk's device ID, giving it a private disk group: %v", t.id, err)
+			id = fmt.Sprintf("thread-%d-private", t.id)
+		} else {
+			// Drop the mount-relative path: it differs between threads whose
+			// directories merely share a filesystem.
+			id = strings.SplitN(id, ":", 2)[0]
+		}
+		ids[i] = id
+	}
+	return groupByDeviceID(threads, ids)
+}
+
+// groupByDeviceID does the actual partitioning of threads into DiskGroups
+// given each thread's already-resolved disk ID (ids[i] is threads[i]'s
+// ID), separated out from groupThreadsByDevice so the partitioning logic
+// can be unit tested without a real filesystem backing each thread's tier-0
+// directory.
+func groupByDeviceID(threads []*Thread, ids []string) []*DiskGroup {
+	groups := map[string]*DiskGroup{}
+	var ordered []*DiskGroup
+	for i, t := range threads {
+		id := ids[i]
+		g, ok := groups[id]
+		if !ok {
+			g = &DiskGroup{deviceID: id}
+			groups[id] = g
+			ordered = append(ordered, g)
+		}
+		g.threads = append(g.threads, t)
+		t.group = g
+	}
+	return ordered
+}
+
+// groupFile identifies a file tracked by one of a DiskGroup's threads.
+type groupFile struct {
+	thread  *Thread
+	name    string
+	modTime time.Time
+}
+
+// oldestFileAcrossGroup finds the next file to evict from tier 0 across
+// every thread in the group, skipping files with pending evictions or
+// active retention-rule protection.  Among the rest, the file with the
+// lowest aggregate retention priority (see evaluateRetention) wins, with
+// ties broken by picking the globally oldest.  Files with active readers are
+// not skipped; evicting one is deferred, see evictFile.
+func (g *DiskGroup) oldestFileAcrossGroup() (groupFile, error) {
+	var oldest groupFile
+	oldestPriority := 0
+	found := false
+	for _, t := range g.threads {
+		t.mu.RLock()
+		tier0 := t.tiers[0]
+		for _, name := range tier0.getSortedFiles() {
+			info, err := os.Stat(tier0.getPacketFilePath(name))
+			if err != nil {
+				log.Printf("Thread %v tier %d could not stat %q: %v", t.id, tier0.idx, name, err)
+				continue
+			}
+			priority := 0
+			if len(t.retention) > 0 {
+				p := t.evaluateRetention(tier0.files[name], info.ModTime())
+				if p.protected() {
+					continue
+				}
+				priority = p.priority
+			}
+			if !found || priority < oldestPriority ||
+				(priority == oldestPriority && info.ModTime().Before(oldest.modTime)) {
+				oldest = groupFile{thread: t, name: name, modTime: info.ModTime()}
+				oldestPriority = priority
+				found = true
+			}
+		}
+		t.mu.RUnlock()
+	}
+	if !found {
+		return groupFile{}, fmt.Errorf("no evictable tier-0 files across the %d thread(s) sharing this disk", len(g.threads))
+	}
+	return oldest, nil
+}
+
+// cleanUp runs tier-0 disk-pressure cleanup for the whole group, triggered
+// by requester's SyncFiles cycle.  Only one cleanup pass runs per group at
+// a time, serialized by g.mu, and each pass evicts the file chosen by
+// oldestFileAcrossGroup across every member thread instead of just
+// requester's own.
+func (g *DiskGroup) cleanUp(requester *Thread) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tier0 := requester.tiers[0]
+	for {
+		df, err := base.PathDiskFreePercentage(tier0.packetPath)
+		if err != nil {
+			log.Printf("DiskGroup %v could not get the free disk percentage for %q: %v", g.deviceID, tier0.packetPath, err)
+			return
+		}
+		if df > tier0.conf.DiskFreePercentage {
+			v(1, "DiskGroup %v disk space is sufficient: %v > %v", g.deviceID, df, tier0.conf.DiskFreePercentage)
+			return
+		}
+		v(0, "DiskGroup %v disk usage is high (packet path=%q): %d%% free\n", g.deviceID, tier0.packetPath, df)
+		victim, err := g.oldestFileAcrossGroup()
+		if err != nil {
+			log.Printf("DiskGroup %v could not free up space: %v", g.deviceID, err)
+			return
+		}
+		victim.thread.mu.Lock()
+		freedNow, err := victim.thread.evictFile(0, victim.name)
+		victim.thread.mu.Unlock()
+		if err != nil {
+			log.Printf("DiskGroup %v could not free up space by evicting %q (thread %v): %v",
+				g.deviceID, victim.name, victim.thread.id, err)
+			return
+		}
+		if !freedNow {
+			// victim has active readers, so it won't actually be gone until
+			// they release it. Stop here instead of picking further group
+			// members' files pending without freeing any real space; the
+			// next SyncFiles cycle will retry once readers/disk stats catch
+			// up.
+			v(1, "DiskGroup %v eviction of %q (thread %v) deferred pending active readers; will retry next cycle",
+				g.deviceID, victim.name, victim.thread.id)
+			return
+		}
+		// After evicting, it may take a while for disk stats to be updated.
+		// We add this sleep so we don't accidentally evict WAY more files
+		// than we need to.
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// ExportDiskDebugHandler exports a single /debug/disk HTTP handler, reporting
+// per-device free space and each thread's contribution to it, grouped by
+// the DiskGroup each thread belongs to.
+func ExportDiskDebugHandler(mux *http.ServeMux, threads []*Thread) {
+	mux.HandleFunc("/debug/disk", func(w http.ResponseWriter, r *http.Request) {
+		w = httputil.New(w, r, false)
+		defer log.Print(w)
+		w.Header().Set("Content-Type", "text/plain")
+		for _, g := range uniqueGroups(threads) {
+			tier0 := g.threads[0].tiers[0]
+			df, err := base.PathDiskFreePercentage(tier0.packetPath)
+			if err != nil {
+				fmt.Fprintf(w, "disk %v: could not get free space: %v\n", g.deviceID, err)
+				continue
+			}
+			fmt.Fprintf(w, "disk %v: %d%% free (threshold %d%%)\n", g.deviceID, df, tier0.conf.DiskFreePercentage)
+			for _, t := range g.threads {
+				t.mu.RLock()
+				fmt.Fprintf(w, "\tthread %d: %d files on %q\n", t.id, len(t.tiers[0].files), t.tiers[0].packetPath)
+				t.mu.RUnlock()
+			}
+		}
+	})
+}
+
+// uniqueGroups returns the distinct DiskGroups backing threads, in the order
+// each group was first encountered.
+func uniqueGroups(threads []*Thread) []*DiskGroup {
+	seen := map[*DiskGroup]bool{}
+	var groups []*DiskGroup
+	for _, t := range threads {
+		if t.group != nil && !seen[t.group] {
+			seen[t.group] = true
+			groups = append(groups, t.group)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,59 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import "testing"
+
+func TestGroupByDeviceIDPartitionsByID(t *testing.T) {
+	threads := []*Thread{{id: 0}, {id: 1}, {id: 2}}
+	ids := []string{"disk-a", "disk-b", "disk-a"}
+
+	groups := groupByDeviceID(threads, ids)
+
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if threads[0].group != threads[2].group {
+		t.Errorf("threads 0 and 2 share a DeviceID but landed in different groups")
+	}
+	if threads[0].group == threads[1].group {
+		t.Errorf("threads 0 and 1 have different DeviceIDs but landed in the same group")
+	}
+	if got := len(threads[0].group.threads); got != 2 {
+		t.Errorf("disk-a group has %d threads, want 2", got)
+	}
+	if got := len(threads[1].group.threads); got != 1 {
+		t.Errorf("disk-b group has %d threads, want 1", got)
+	}
+}
+
+func TestGroupByDeviceIDEachThreadAssignedItsGroup(t *testing.T) {
+	threads := []*Thread{{id: 0}, {id: 1}}
+	ids := []string{"thread-0-private", "thread-1-private"}
+
+	groups := groupByDeviceID(threads, ids)
+
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	for i, th := range threads {
+		if th.group == nil {
+			t.Fatalf("thread %d has a nil group", i)
+		}
+		if len(th.group.threads) != 1 || th.group.threads[0] != th {
+			t.Errorf("thread %d's group does not contain exactly itself", i)
+		}
+	}
+}
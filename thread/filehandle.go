@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import (
+	"sync"
+
+	"github.com/google/stenographer/blockfile"
+)
+
+// fileHandle wraps a blockfile.BlockFile with a reader refcount, so that
+// eviction (deletion or cross-tier migration) of a file can be deferred
+// until any in-flight Lookup holding it has finished, instead of yanking the
+// file out from under a reader.  This is analogous to syncthing's
+// sharedpullerstate, which tracks outstanding references to a file being
+// synced.
+type fileHandle struct {
+	mu       sync.Mutex
+	bf       *blockfile.BlockFile
+	refs     int
+	deleting bool
+	onDrain  func()
+}
+
+func newFileHandle(bf *blockfile.BlockFile) *fileHandle {
+	return &fileHandle{bf: bf}
+}
+
+// acquire bumps the reader refcount and returns the underlying blockfile, or
+// returns nil if the file has already been marked for eviction.  Every
+// successful acquire must be paired with a call to release.
+func (f *fileHandle) acquire() *blockfile.BlockFile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleting {
+		return nil
+	}
+	f.refs++
+	return f.bf
+}
+
+// release drops a reader reference taken by acquire.  If the file has been
+// marked for eviction and this was the last reader, it runs the deferred
+// eviction callback registered by markDeleting.
+func (f *fileHandle) release() {
+	f.mu.Lock()
+	f.refs--
+	var onDrain func()
+	if f.refs <= 0 && f.deleting {
+		onDrain, f.onDrain = f.onDrain, nil
+	}
+	f.mu.Unlock()
+	if onDrain != nil {
+		onDrain()
+	}
+}
+
+// isDeleting reports whether the file has been marked for eviction, so
+// callers (like getSortedFiles) can stop handing it out to new lookups.
+func (f *fileHandle) isDeleting() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deleting
+}
+
+// markDeleting marks the file as pending eviction.  If no readers currently
+// hold the file, it returns true (ranNow) and the caller is responsible for
+// performing the eviction itself, immediately, using whatever lock it
+// already holds.  Otherwise onDrain is stored to run later, once the last
+// active reader calls release, and markDeleting returns false.  Callers
+// driving a disk-pressure retry loop should stop at the first false instead
+// of moving on to the next candidate: see evictFile.
+func (f *fileHandle) markDeleting(onDrain func()) (ranNow bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleting = true
+	if f.refs == 0 {
+		return true
+	}
+	f.onDrain = onDrain
+	return false
+}
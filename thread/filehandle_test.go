@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import (
+	"testing"
+
+	"github.com/google/stenographer/blockfile"
+)
+
+func TestFileHandleAcquireRelease(t *testing.T) {
+	bf := new(blockfile.BlockFile)
+	f := newFileHandle(bf)
+
+	got := f.acquire()
+	if got != bf {
+		t.Fatalf("acquire() = %v, want %v", got, bf)
+	}
+	if f.refs != 1 {
+		t.Fatalf("refs = %d, want 1", f.refs)
+	}
+
+	f.release()
+	if f.refs != 0 {
+		t.Fatalf("refs = %d, want 0 after release", f.refs)
+	}
+}
+
+func TestFileHandleAcquireAfterMarkDeletingFails(t *testing.T) {
+	f := newFileHandle(new(blockfile.BlockFile))
+
+	if ranNow := f.markDeleting(func() {}); !ranNow {
+		t.Fatalf("markDeleting() = false, want true for a file with no active readers")
+	}
+	if !f.isDeleting() {
+		t.Fatalf("isDeleting() = false, want true after markDeleting")
+	}
+	if got := f.acquire(); got != nil {
+		t.Fatalf("acquire() = %v, want nil once marked deleting", got)
+	}
+}
+
+func TestFileHandleMarkDeletingDefersUntilLastReleaseWithActiveReaders(t *testing.T) {
+	f := newFileHandle(new(blockfile.BlockFile))
+
+	if f.acquire() == nil {
+		t.Fatalf("acquire() = nil, want non-nil before markDeleting")
+	}
+	if f.acquire() == nil {
+		t.Fatalf("acquire() = nil, want non-nil before markDeleting")
+	}
+
+	drained := false
+	if ranNow := f.markDeleting(func() { drained = true }); ranNow {
+		t.Fatalf("markDeleting() = true, want false with active readers")
+	}
+
+	f.release()
+	if drained {
+		t.Fatalf("onDrain ran after first release, want it to wait for the last reader")
+	}
+
+	f.release()
+	if !drained {
+		t.Fatalf("onDrain did not run after last release")
+	}
+}
+
+func TestFileHandleMarkDeletingNeverRunsOnDrainItself(t *testing.T) {
+	// markDeleting must never invoke onDrain synchronously: callers that
+	// already hold t.mu rely on running the eviction themselves when
+	// ranNow is true, and would deadlock if onDrain (which re-acquires
+	// t.mu for the deferred path) ran inline here instead.
+	f := newFileHandle(new(blockfile.BlockFile))
+	f.acquire()
+
+	called := false
+	f.markDeleting(func() { called = true })
+	if called {
+		t.Fatalf("markDeleting invoked onDrain itself; it must only store it for release to call")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveFileSameFilesystemRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "movefile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatalf("moveFile() = %v, want nil", err)
+	}
+	assertMoved(t, src, dst, "hello")
+}
+
+func TestCopyAndRemoveFile(t *testing.T) {
+	// moveFile only falls back to copyAndRemoveFile on a cross-filesystem
+	// EXDEV rename error, which a single tmpdir can't reproduce in a test
+	// sandbox.  Exercise copyAndRemoveFile directly instead, since it's the
+	// part of the fallback path most likely to silently misbehave.
+	dir, err := ioutil.TempDir("", "movefile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, []byte("world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyAndRemoveFile(src, dst); err != nil {
+		t.Fatalf("copyAndRemoveFile() = %v, want nil", err)
+	}
+	assertMoved(t, src, dst, "world")
+}
+
+// assertMoved checks that src no longer exists and dst exists with the given
+// contents.
+func assertMoved(t *testing.T, src, dst, wantContents string) {
+	t.Helper()
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) err = %v, want a not-exist error", src, err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("could not read %q: %v", dst, err)
+	}
+	if string(got) != wantContents {
+		t.Errorf("%q contents = %q, want %q", dst, got, wantContents)
+	}
+}
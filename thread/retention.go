@@ -0,0 +1,168 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/stenographer/config"
+	"github.com/google/stenographer/query"
+	"golang.org/x/net/context"
+)
+
+// retentionRule is a parsed, ready-to-evaluate config.RetentionRule.
+type retentionRule struct {
+	raw      string
+	query    query.Query
+	minAge   time.Duration
+	priority int
+}
+
+// parseRetentionRules parses a thread's configured retention rules, failing
+// fast at startup if any of them aren't valid stenographer queries.
+func parseRetentionRules(confs []config.RetentionRule) ([]*retentionRule, error) {
+	var rules []*retentionRule
+	for _, c := range confs {
+		q, err := query.NewQuery(c.Query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention rule query %q: %v", c.Query, err)
+		}
+		rules = append(rules, &retentionRule{
+			raw:      c.Query,
+			query:    q,
+			minAge:   c.MinAge,
+			priority: c.Priority,
+		})
+	}
+	return rules, nil
+}
+
+// protection describes how a file is currently protected by this thread's
+// retention rules: the rules still shielding it from eviction, its combined
+// priority (the sum of those rules' priorities, used to rank eviction
+// candidates against each other), and the latest time at which it'll stop
+// being protected.
+type protection struct {
+	rules    []string
+	priority int
+	until    time.Time
+}
+
+// protected reports whether the file is still shielded from eviction by any
+// rule.
+func (p protection) protected() bool {
+	return len(p.rules) > 0
+}
+
+// evaluateRetention checks a tracked file against the thread's retention
+// rules, reporting which ones currently protect it.  modTime is the file's
+// creation time, used to measure each rule's MinAge against; bf is used to
+// check whether the file's index still contains packets matching a rule's
+// query.
+//
+// A rule that matches the file's contents contributes to p.priority
+// regardless of whether its retention window has already elapsed, so that
+// files can still be ranked against each other by priority once every
+// matching rule's MinAge has passed.  Only rules whose window hasn't
+// elapsed yet count toward p.rules/p.until, ie. actually protect the file
+// from eviction right now.
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) evaluateRetention(fh *fileHandle, modTime time.Time) protection {
+	var p protection
+	age := time.Since(modTime)
+	for _, r := range t.retention {
+		positions, err := fh.bf.Positions(context.Background(), r.query)
+		if err != nil {
+			v(1, "Thread %v retention rule %q failed against %q: %v", t.id, r.raw, fh.bf.Name(), err)
+			continue
+		}
+		if !positions.IsAllPositions() && len(positions) == 0 {
+			continue // rule doesn't match anything in this file
+		}
+		p.priority += r.priority
+		if age < r.minAge {
+			p.rules = append(p.rules, r.raw)
+			eviction := modTime.Add(r.minAge)
+			if eviction.After(p.until) {
+				p.until = eviction
+			}
+		}
+	}
+	return p
+}
+
+// pickEvictionCandidate chooses which file in tier i to evict next,
+// honoring the thread's retention rules: files still protected by an
+// unexpired rule are skipped entirely, and among the rest, files are ranked
+// by ascending aggregate priority (so low-priority traffic goes before
+// high-priority traffic that's merely old) with ties broken by age.
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) pickEvictionCandidate(i int) (string, error) {
+	tr := t.tiers[i]
+	sorted := tr.getSortedFiles()
+	if len(t.retention) == 0 {
+		if len(sorted) == 0 {
+			return "", fmt.Errorf("tier %d has no evictable files (all may be pending eviction)", tr.idx)
+		}
+		return sorted[0], nil
+	}
+	var candidates []evictionCandidate
+	for rank, name := range sorted {
+		fh := tr.files[name]
+		info, err := os.Stat(tr.getPacketFilePath(name))
+		if err != nil {
+			log.Printf("Thread %v tier %d could not stat %q for retention check: %v", t.id, tr.idx, name, err)
+			continue
+		}
+		p := t.evaluateRetention(fh, info.ModTime())
+		if p.protected() {
+			continue
+		}
+		candidates = append(candidates, evictionCandidate{name: name, priority: p.priority, rank: rank})
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("tier %d has no evictable files (all protected by retention rules or pending eviction)", tr.idx)
+	}
+	return pickLowestPriority(candidates), nil
+}
+
+// evictionCandidate is a file eligible for eviction from a tier: unprotected
+// by any still-active retention rule, annotated with its aggregate priority
+// (see evaluateRetention) and its age rank (0 = oldest) among its peers.
+type evictionCandidate struct {
+	name     string
+	priority int
+	rank     int
+}
+
+// pickLowestPriority picks which of several eviction candidates to evict
+// first: the one with the lowest aggregate retention priority, so
+// low-priority traffic goes before high-priority traffic that's merely old,
+// breaking ties by age (lower rank = older = evicted first). candidates must
+// be non-empty.
+func pickLowestPriority(candidates []evictionCandidate) string {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.priority < best.priority || (c.priority == best.priority && c.rank < best.rank) {
+			best = c
+		}
+	}
+	return best.name
+}
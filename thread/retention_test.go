@@ -0,0 +1,60 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thread
+
+import "testing"
+
+func TestPickLowestPriority(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		candidates []evictionCandidate
+		want       string
+	}{
+		{
+			name:       "single candidate",
+			candidates: []evictionCandidate{{name: "a", priority: 5, rank: 0}},
+			want:       "a",
+		},
+		{
+			name: "lowest priority wins even if younger",
+			candidates: []evictionCandidate{
+				{name: "old-high-priority", priority: 10, rank: 0},
+				{name: "young-low-priority", priority: 1, rank: 3},
+			},
+			want: "young-low-priority",
+		},
+		{
+			name: "equal priority breaks tie by age, oldest first",
+			candidates: []evictionCandidate{
+				{name: "younger", priority: 2, rank: 2},
+				{name: "older", priority: 2, rank: 0},
+				{name: "middle", priority: 2, rank: 1},
+			},
+			want: "older",
+		},
+		{
+			name: "priority differences are not swamped by age",
+			candidates: []evictionCandidate{
+				{name: "bulk-video", priority: 0, rank: 5},
+				{name: "dns-just-past-minage", priority: 100, rank: 0},
+			},
+			want: "bulk-video",
+		},
+	} {
+		if got := pickLowestPriority(test.candidates); got != test.want {
+			t.Errorf("%s: pickLowestPriority() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
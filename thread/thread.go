@@ -19,6 +19,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -27,6 +28,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/stenographer/base"
@@ -44,17 +46,32 @@ const (
 	indexPrefix  = "IDX"
 )
 
+// tier tracks the on-disk state for a single storage tier of a thread:  its
+// directories and the blockfiles currently known to live in them.
+type tier struct {
+	idx        int
+	conf       config.StorageTier
+	indexPath  string
+	packetPath string
+	files      map[string]*fileHandle
+}
+
 // Thread watches the environment of a single stenotype thread.
 // Stenotype runs multiple threads, each of which interacts with a specific set
 // of directories in the environment.  Each stenotype thread has a corresponding
 // Thread object server-side which watches for file changes, cleans up old/dead
 // files, etc.
+//
+// A thread may be backed by more than one storage tier (eg. fast NVMe
+// backing a slower archive mount); see config.StorageTier.  Files start out
+// on the first tier and migrate toward the last as earlier tiers run low on
+// space, only being deleted once they fall off the last tier.
 type Thread struct {
 	id           int
 	conf         config.ThreadConfig
-	indexPath    string
-	packetPath   string
-	files        map[string]*blockfile.BlockFile
+	tiers        []*tier
+	retention    []*retentionRule
+	group        *DiskGroup
 	mu           sync.RWMutex
 	fileLastSeen time.Time
 }
@@ -63,22 +80,45 @@ type Thread struct {
 func Threads(configs []config.ThreadConfig, baseDir string) ([]*Thread, error) {
 	threads := make([]*Thread, len(configs))
 	for i, conf := range configs {
+		rules, err := parseRetentionRules(conf.RetentionRules)
+		if err != nil {
+			return nil, fmt.Errorf("thread %v has invalid retention rules: %v", i, err)
+		}
 		thread := &Thread{
 			id:           i,
 			conf:         conf,
-			indexPath:    filepath.Join(baseDir, indexPrefix+strconv.Itoa(i)),
-			packetPath:   filepath.Join(baseDir, packetPrefix+strconv.Itoa(i)),
-			files:        map[string]*blockfile.BlockFile{},
+			retention:    rules,
 			fileLastSeen: time.Now(),
 		}
+		for tierIdx, tierConf := range conf.StorageTiers() {
+			thread.tiers = append(thread.tiers, &tier{
+				idx:        tierIdx,
+				conf:       tierConf,
+				indexPath:  tierSymlinkPath(baseDir, indexPrefix, i, tierIdx),
+				packetPath: tierSymlinkPath(baseDir, packetPrefix, i, tierIdx),
+				files:      map[string]*fileHandle{},
+			})
+		}
 		if err := thread.createSymlinks(); err != nil {
 			return nil, err
 		}
 		threads[i] = thread
 	}
+	groupThreadsByDevice(threads)
 	return threads, nil
 }
 
+// tierSymlinkPath returns the path used within baseDir for a given thread's
+// tier.  Tier 0 keeps the original, pre-tiering name so existing single-tier
+// deployments don't need their on-disk symlinks renamed.
+func tierSymlinkPath(baseDir, prefix string, threadID, tierIdx int) string {
+	name := prefix + strconv.Itoa(threadID)
+	if tierIdx > 0 {
+		name += "_T" + strconv.Itoa(tierIdx)
+	}
+	return filepath.Join(baseDir, name)
+}
+
 func makeDirIfNecessary(dir string) error {
 	if stat, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0700); err != nil {
@@ -93,56 +133,60 @@ func makeDirIfNecessary(dir string) error {
 }
 
 func (t *Thread) createSymlinks() error {
-	if err := makeDirIfNecessary(t.conf.PacketsDirectory); err != nil {
-		return fmt.Errorf("thread %v could not create packet directory: %v", t.id, err)
-	}
-	if err := os.Symlink(t.conf.PacketsDirectory, t.packetPath); err != nil {
-		return fmt.Errorf("couldn't create symlink for thread %d to directory %q: %v",
-			t.id, t.conf.PacketsDirectory, err)
-	}
-	if err := makeDirIfNecessary(t.conf.IndexDirectory); err != nil {
-		return fmt.Errorf("thread %v could not create index directory: %v", t.id, err)
-	}
-	if err := os.Symlink(t.conf.IndexDirectory, t.indexPath); err != nil {
-		return fmt.Errorf("couldn't create symlink for index %d to directory %q: %v",
-			t.id, t.conf.IndexDirectory, err)
+	for _, tr := range t.tiers {
+		if err := makeDirIfNecessary(tr.conf.PacketsDirectory); err != nil {
+			return fmt.Errorf("thread %v tier %d could not create packet directory: %v", t.id, tr.idx, err)
+		}
+		if err := os.Symlink(tr.conf.PacketsDirectory, tr.packetPath); err != nil {
+			return fmt.Errorf("couldn't create symlink for thread %d tier %d to directory %q: %v",
+				t.id, tr.idx, tr.conf.PacketsDirectory, err)
+		}
+		if err := makeDirIfNecessary(tr.conf.IndexDirectory); err != nil {
+			return fmt.Errorf("thread %v tier %d could not create index directory: %v", t.id, tr.idx, err)
+		}
+		if err := os.Symlink(tr.conf.IndexDirectory, tr.indexPath); err != nil {
+			return fmt.Errorf("couldn't create symlink for index %d tier %d to directory %q: %v",
+				t.id, tr.idx, tr.conf.IndexDirectory, err)
+		}
 	}
 	return nil
 }
 
-func (t *Thread) getPacketFilePath(filename string) string {
-	return filepath.Join(t.packetPath, filename)
+func (tr *tier) getPacketFilePath(filename string) string {
+	return filepath.Join(tr.packetPath, filename)
 }
 
-func (t *Thread) getIndexFilePath(filename string) string {
-	return filepath.Join(t.indexPath, filename)
+func (tr *tier) getIndexFilePath(filename string) string {
+	return filepath.Join(tr.indexPath, filename)
 }
 
 func (t *Thread) syncFilesWithDisk() {
-	newFilesCnt := 0
-	for _, filename := range t.listPacketFilesOnDisk() {
-		if t.files[filename] != nil {
-			continue
+	for _, tr := range t.tiers {
+		newFilesCnt := 0
+		for _, filename := range tr.listPacketFilesOnDisk(t.id) {
+			if tr.files[filename] != nil {
+				continue
+			}
+			if err := t.trackNewFile(tr, filename); err != nil {
+				log.Printf("Thread %v tier %d error tracking %q: %v", t.id, tr.idx, filename, err)
+				continue
+			}
+			newFilesCnt++
+			t.fileLastSeen = time.Now()
 		}
-		if err := t.trackNewFile(filename); err != nil {
-			log.Printf("Thread %v error tracking %q: %v", t.id, filename, err)
-			continue
+		if newFilesCnt > 0 {
+			v(0, "Thread %v tier %d found %d new blockfiles", t.id, tr.idx, newFilesCnt)
 		}
-		newFilesCnt++
-		t.fileLastSeen = time.Now()
-	}
-	if newFilesCnt > 0 {
-		v(0, "Thread %v found %d new blockfiles", t.id, newFilesCnt)
 	}
 }
 
-func (t *Thread) listPacketFilesOnDisk() (out []string) {
+func (tr *tier) listPacketFilesOnDisk(threadID int) (out []string) {
 	// Since indexes tend to be written after blockfiles, we list index files,
 	// then translate them back to blockfiles.  This way, we don't get spurious
 	// errors when we find blockfiles that indexes haven't been written for yet.
-	files, err := ioutil.ReadDir(t.indexPath)
+	files, err := ioutil.ReadDir(tr.indexPath)
 	if err != nil {
-		log.Printf("Thread %v could not read dir %q: %v", t.id, t.indexPath, err)
+		log.Printf("Thread %v tier %d could not read dir %q: %v", threadID, tr.idx, tr.indexPath, err)
 		return nil
 	}
 	for _, file := range files {
@@ -155,84 +199,250 @@ func (t *Thread) listPacketFilesOnDisk() (out []string) {
 }
 
 // This method should only be called once the t.mu has been acquired!
-func (t *Thread) trackNewFile(filename string) error {
-	filepath := filepath.Join(t.packetPath, filename)
+func (t *Thread) trackNewFile(tr *tier, filename string) error {
+	filepath := tr.getPacketFilePath(filename)
 	bf, err := blockfile.NewBlockFile(filepath)
 	if err != nil {
 		return fmt.Errorf("could not open blockfile %q: %v", filepath, err)
 	}
 	v(1, "new blockfile %q", filepath)
-	t.files[filename] = bf
+	tr.files[filename] = newFileHandle(bf)
 	return nil
 }
 
-func (t *Thread) cleanUpOnLowDiskSpace() {
+// cleanUpOtherTiers walks every tier but the first, migrating files (see
+// evictOldestFile) off of any that's running low on disk space and onto the
+// next one.
+// Only the last tier actually deletes files.
+//
+// Tier 0 is handled separately, by this thread's DiskGroup: it's the tier
+// whose directory DeviceID was computed from, and so it's the one other
+// threads might share a physical disk with.
+func (t *Thread) cleanUpOtherTiers() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := 1; i < len(t.tiers); i++ {
+		t.cleanUpTier(i)
+	}
+}
+
+// evictFilesPastMaxAge evicts every file on tier i older than the tier's
+// configured MaxAge, regardless of current disk pressure, skipping files
+// still protected by a retention rule.  It's a no-op if
+// MaxAge is zero, which means "no age limit".
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) evictFilesPastMaxAge(i int) {
+	tr := t.tiers[i]
+	if tr.conf.MaxAge == 0 {
+		return
+	}
+	for _, name := range tr.getSortedFiles() {
+		info, err := os.Stat(tr.getPacketFilePath(name))
+		if err != nil {
+			log.Printf("Thread %v tier %d could not stat %q for MaxAge check: %v", t.id, tr.idx, name, err)
+			continue
+		}
+		if time.Since(info.ModTime()) < tr.conf.MaxAge {
+			// getSortedFiles is oldest-first, so once we hit a file that's
+			// still within MaxAge, every file after it is too.
+			return
+		}
+		if len(t.retention) > 0 && t.evaluateRetention(tr.files[name], info.ModTime()).protected() {
+			continue
+		}
+		v(1, "Thread %v tier %d evicting %q: older than MaxAge %v", t.id, tr.idx, name, tr.conf.MaxAge)
+		if _, err := t.evictFile(i, name); err != nil {
+			log.Printf("Thread %v tier %d could not evict %q past MaxAge: %v", t.id, tr.idx, name, err)
+			return
+		}
+	}
+}
+
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) cleanUpTier(i int) {
+	tr := t.tiers[i]
+	t.evictFilesPastMaxAge(i)
 	for {
-		df, err := base.PathDiskFreePercentage(t.packetPath)
+		df, err := base.PathDiskFreePercentage(tr.packetPath)
 		if err != nil {
-			log.Printf("Thread %v could not get the free disk percentage for %q: %v", t.id, t.packetPath, err)
+			log.Printf("Thread %v tier %d could not get the free disk percentage for %q: %v", t.id, tr.idx, tr.packetPath, err)
 			return
 		}
-		if df > t.conf.DiskFreePercentage {
-			v(1, "Thread %v disk space is sufficient: %v > %v", t.id, df, t.conf.DiskFreePercentage)
+		if df > tr.conf.DiskFreePercentage {
+			v(1, "Thread %v tier %d disk space is sufficient: %v > %v", t.id, tr.idx, df, tr.conf.DiskFreePercentage)
+			return
+		}
+		v(0, "Thread %v tier %d disk usage is high (packet path=%q): %d%% free\n", t.id, tr.idx, tr.packetPath, df)
+		freedNow, err := t.evictOldestFile(i)
+		if err != nil {
+			log.Printf("Thread %v tier %d could not free up space by evicting old files: %v", t.id, tr.idx, err)
 			return
 		}
-		v(0, "Thread %v disk usage is high (packet path=%q): %d%% free\n", t.id, t.packetPath, df)
-		if len(t.files) == 0 {
-			log.Printf("Thread %v could not free up space:  no files available", t.id)
-		} else if err := t.deleteOldestThreadFile(); err != nil {
-			log.Printf("Thread %v could not free up space by deleting old files: %v", t.id, err)
+		if !freedNow {
+			// The oldest evictable candidate has active readers, so its
+			// removal/migration won't complete within this SyncFiles cycle.
+			// Stop here rather than marking every remaining candidate
+			// pending without freeing any actual space; the next cycle
+			// will pick up where this one left off once disk stats (and
+			// readers) catch up.
+			v(1, "Thread %v tier %d eviction deferred pending active readers; will retry next cycle", t.id, tr.idx)
 			return
 		}
-		// After deleting files, it may take a while for disk stats to be updated.
-		// We add this sleep so we don't accidentally delete WAY more files than
-		// we need to.
+		// After deleting/migrating files, it may take a while for disk stats to
+		// be updated.  We add this sleep so we don't accidentally evict WAY
+		// more files than we need to.
 		time.Sleep(100 * time.Millisecond)
 	}
 }
 
-// deleteOldestThreadFile deletes the single oldest file held by this thread.
-// It should only be called if the thread has at least one file (should be
-// checked by the caller beforehand).
-func (t *Thread) deleteOldestThreadFile() error {
-	oldestFile := t.getSortedFiles()[0]
-	v(1, "Thread %v removing %q", t.id, oldestFile)
-	if err := os.Remove(t.getPacketFilePath(oldestFile)); err != nil {
+// evictOldestFile picks the next file to evict from tier i via
+// pickEvictionCandidate (the oldest file, or with retention rules the
+// lowest-priority unprotected one, skipping files already pending
+// eviction) and evicts it via evictFile.  freedNow reports whether space was actually
+// freed, as opposed to merely queued for eviction once active readers drop
+// it; see evictFile.
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) evictOldestFile(i int) (freedNow bool, err error) {
+	filename, err := t.pickEvictionCandidate(i)
+	if err != nil {
+		return false, err
+	}
+	return t.evictFile(i, filename)
+}
+
+// evictFile evicts the named file from tier i: deleting it if i is the last
+// tier, or migrating it to tier i+1 otherwise.  If the file currently has
+// active readers, the actual removal/migration is deferred until the last
+// reader releases it, and the file is excluded from getSortedFiles in the
+// meantime so new Lookups don't pick it up; freedNow is false in that case,
+// since no space was actually freed yet.  Callers driving a disk-pressure
+// retry loop should stop polling once freedNow comes back false instead of
+// moving on to the next candidate: the file that was just deferred is the
+// reclaim this cycle is waiting on, and picking further candidates only
+// marks more (possibly much fresher) files pending without freeing anything
+// sooner.
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) evictFile(i int, filename string) (freedNow bool, err error) {
+	tr := t.tiers[i]
+	fh := tr.files[filename]
+	if fh == nil {
+		return false, fmt.Errorf("tier %d has no tracked file %q to evict", tr.idx, filename)
+	}
+	ranNow := fh.markDeleting(func() {
+		// This runs asynchronously, from whichever Lookup happens to release
+		// the last reference, long after this call has returned - so unlike
+		// the ranNow case below, it must acquire t.mu itself.
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if err := t.finishEviction(i, filename, fh); err != nil {
+			log.Printf("Thread %v tier %d error finishing deferred eviction of %q: %v", t.id, tr.idx, filename, err)
+		}
+	})
+	if !ranNow {
+		v(1, "Thread %v tier %d deferring eviction of %q until its active readers finish", t.id, tr.idx, filename)
+		return false, nil
+	}
+	return true, t.finishEviction(i, filename, fh)
+}
+
+// finishEviction performs the actual removal or migration of filename from
+// tier i, once it's known to have no active readers.
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) finishEviction(i int, filename string, fh *fileHandle) error {
+	tr := t.tiers[i]
+	if i == len(t.tiers)-1 {
+		v(1, "Thread %v tier %d removing %q", t.id, tr.idx, filename)
+		if err := os.Remove(tr.getPacketFilePath(filename)); err != nil {
+			return err
+		}
+		if err := os.Remove(tr.getIndexFilePath(filename)); err != nil {
+			return err
+		}
+		fh.bf.Close()
+		delete(tr.files, filename)
+		return nil
+	}
+	dst := t.tiers[i+1]
+	v(1, "Thread %v migrating %q from tier %d to tier %d", t.id, filename, tr.idx, dst.idx)
+	// Move the (much smaller) index first.  If the packet move then fails,
+	// we can cheaply roll the index back to tier i, leaving the file intact
+	// on its original tier rather than orphaning a packet on tier i+1 with
+	// no index to match it (or vice versa).
+	if err := moveFile(tr.getIndexFilePath(filename), dst.getIndexFilePath(filename)); err != nil {
+		return fmt.Errorf("could not migrate index file %q: %v", filename, err)
+	}
+	if err := moveFile(tr.getPacketFilePath(filename), dst.getPacketFilePath(filename)); err != nil {
+		if rerr := moveFile(dst.getIndexFilePath(filename), tr.getIndexFilePath(filename)); rerr != nil {
+			log.Printf("Thread %v tier %d could not roll back index migration of %q after packet migration to tier %d failed: %v", t.id, tr.idx, filename, dst.idx, rerr)
+		}
+		return fmt.Errorf("could not migrate packet file %q: %v", filename, err)
+	}
+	fh.bf.Close()
+	delete(tr.files, filename)
+	return t.trackNewFile(dst, filename)
+}
+
+// moveFile renames src to dst, falling back to a copy+fsync+unlink when the
+// rename fails because src and dst live on different filesystems.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	linkErr, ok := err.(*os.LinkError)
+	if !ok || linkErr.Err != syscall.EXDEV {
 		return err
 	}
-	if err := os.Remove(t.getIndexFilePath(oldestFile)); err != nil {
+	return copyAndRemoveFile(src, dst)
+}
+
+func copyAndRemoveFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
 		return err
 	}
-	return t.untrackFile(oldestFile)
+	return os.Remove(src)
 }
 
-// getSortedFiles returns files frm the thread in the order they were created,
-// and thus in the order their packets should appear.
+// getSortedFiles returns files from the tier in the order they were created,
+// and thus in the order their packets should appear.  Files already marked
+// for eviction are excluded, so new Lookups don't race to read a file that's
+// about to be removed or migrated out from under them.
 //
-// This method should only be called once the t.mu has been acquired!
-func (t *Thread) getSortedFiles() []string {
+// This method should only be called once the owning Thread's t.mu has been
+// acquired!
+func (tr *tier) getSortedFiles() []string {
 	var sortedFiles []string
-	for name, _ := range t.files {
+	for name, fh := range tr.files {
+		if fh.isDeleting() {
+			continue
+		}
 		sortedFiles = append(sortedFiles, name)
 	}
 	sort.Strings(sortedFiles)
 	return sortedFiles
 }
 
-// This method should only be called once the t.mu has been acquired!
-func (t *Thread) untrackFile(filename string) error {
-	v(1, "Thread %v untracking %q", t.id, filename)
-	b := t.files[filename]
-	if b == nil {
-		return fmt.Errorf("trying to untrack file %q for thread %d, but that file is not monitored",
-			t.getPacketFilePath(filename), t.id)
-	}
-	v(1, "Thread %v old blockfile %q", t.id, b.Name())
-	b.Close()
-	delete(t.files, filename)
-	return nil
-}
-
 func (t *Thread) FileLastSeen() time.Time {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -242,22 +452,49 @@ func (t *Thread) FileLastSeen() time.Time {
 const concurrentBlockfileReadsPerThread = 10
 
 // Lookup looks up packets that match a given query within the files owned by a
-// single stenotype thread.
+// single stenotype thread, searching across all of its storage tiers (hot to
+// cold) so that queries transparently span migrated/archived files.
+//
+// Unlike SyncFiles, Lookup only holds t.mu long enough to snapshot the
+// current set of files and bump each one's reader refcount; the actual
+// search runs against those acquired blockfiles with the thread lock
+// released, so a long-running query no longer blocks cleanup (or vice
+// versa).  Any file evicted while the query is still running stays open
+// until this Lookup's goroutine releases it.
 func (t *Thread) Lookup(ctx context.Context, q query.Query) *base.PacketChan {
 	t.mu.RLock()
+	var handles []*fileHandle
+	var files []*blockfile.BlockFile
+	for _, tr := range t.tiers {
+		for _, name := range tr.getSortedFiles() {
+			fh := tr.files[name]
+			bf := fh.acquire()
+			if bf == nil {
+				// Lost a race with an eviction that just marked this file
+				// deleting; skip it rather than reading a half-evicted file.
+				continue
+			}
+			handles = append(handles, fh)
+			files = append(files, bf)
+		}
+	}
+	t.mu.RUnlock()
+
 	inputs := make(chan *base.PacketChan, concurrentBlockfileReadsPerThread)
 	out := base.ConcatPacketChans(ctx, inputs)
 	go func() {
 		defer func() {
 			close(inputs)
 			<-out.Done()
-			t.mu.RUnlock()
+			for _, fh := range handles {
+				fh.release()
+			}
 		}()
-		for _, file := range t.getSortedFiles() {
+		for _, bf := range files {
 			packets := base.NewPacketChan(100)
 			select {
 			case inputs <- packets:
-				go t.files[file].Lookup(ctx, q, packets)
+				go bf.Lookup(ctx, q, packets)
 			case <-ctx.Done():
 				return
 			}
@@ -271,8 +508,20 @@ func (t *Thread) Lookup(ctx context.Context, q query.Query) *base.PacketChan {
 func (t *Thread) SyncFiles() {
 	t.mu.Lock()
 	t.syncFilesWithDisk()
-	t.cleanUpOnLowDiskSpace()
+	t.evictFilesPastMaxAge(0)
 	t.mu.Unlock()
+	// Tier 0's disk-pressure cleanup is coordinated across every thread that
+	// shares its disk; the rest of the tiers (if any), along with their own
+	// MaxAge eviction, are cleaned up independently.
+	if t.group != nil {
+		t.group.cleanUp(t)
+	} else {
+		// Threads always assigns a group (even a private, single-member one
+		// when DeviceID can't be determined); this only fires for a Thread
+		// built some other way.
+		log.Printf("Thread %v has no DiskGroup assigned; skipping tier-0 disk-pressure cleanup this cycle", t.id)
+	}
+	t.cleanUpOtherTiers()
 }
 
 // ExportDebugHandlers exports a set of HTTP handlers on /debug/t<thread id> for
@@ -283,20 +532,54 @@ func (t *Thread) ExportDebugHandlers(mux *http.ServeMux) {
 		w = httputil.New(w, r, false)
 		defer log.Print(w)
 		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprintf(w, "Thread %d (IDX: %q, PKT: %q)\n", t.id, t.indexPath, t.packetPath)
 		t.mu.RLock()
-		for name := range t.files {
-			fmt.Fprintf(w, "\t%v\n", name)
+		for _, tr := range t.tiers {
+			fmt.Fprintf(w, "Thread %d tier %d (IDX: %q, PKT: %q)\n", t.id, tr.idx, tr.indexPath, tr.packetPath)
+			for name, fh := range tr.files {
+				if fh.isDeleting() {
+					fmt.Fprintf(w, "\t%v (pending eviction)\n", name)
+				} else {
+					fmt.Fprintf(w, "\t%v\n", name)
+				}
+			}
 		}
 		t.mu.RUnlock()
 	})
+	mux.HandleFunc(prefix+"/retention", func(w http.ResponseWriter, r *http.Request) {
+		w = httputil.New(w, r, false)
+		defer log.Print(w)
+		w.Header().Set("Content-Type", "text/plain")
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		if len(t.retention) == 0 {
+			fmt.Fprintf(w, "Thread %d has no retention rules configured\n", t.id)
+			return
+		}
+		for _, tr := range t.tiers {
+			fmt.Fprintf(w, "Thread %d tier %d:\n", t.id, tr.idx)
+			for name, fh := range tr.files {
+				info, err := os.Stat(tr.getPacketFilePath(name))
+				if err != nil {
+					fmt.Fprintf(w, "\t%v: could not stat: %v\n", name, err)
+					continue
+				}
+				p := t.evaluateRetention(fh, info.ModTime())
+				if !p.protected() {
+					fmt.Fprintf(w, "\t%v: unprotected, evictable now\n", name)
+					continue
+				}
+				fmt.Fprintf(w, "\t%v: protected by %v (priority %d) until %v\n",
+					name, p.rules, p.priority, p.until.Format(time.RFC3339))
+			}
+		}
+	})
 	mux.HandleFunc(prefix+"/index", func(w http.ResponseWriter, r *http.Request) {
 		w = httputil.New(w, r, false)
 		defer log.Print(w)
 		t.mu.RLock()
 		defer t.mu.RUnlock()
 		vals := r.URL.Query()
-		file := t.files[vals.Get("name")]
+		file := t.findFile(vals.Get("name"))
 		if file == nil {
 			http.Error(w, "file not found", http.StatusNotFound)
 			return
@@ -326,7 +609,7 @@ func (t *Thread) ExportDebugHandlers(mux *http.ServeMux) {
 		t.mu.RLock()
 		defer t.mu.RUnlock()
 		vals := r.URL.Query()
-		file := t.files[vals.Get("name")]
+		file := t.findFile(vals.Get("name"))
 		if file == nil {
 			http.Error(w, "file not found", http.StatusNotFound)
 			return
@@ -340,7 +623,7 @@ func (t *Thread) ExportDebugHandlers(mux *http.ServeMux) {
 		t.mu.RLock()
 		defer t.mu.RUnlock()
 		vals := r.URL.Query()
-		file := t.files[vals.Get("name")]
+		file := t.findFile(vals.Get("name"))
 		if file == nil {
 			http.Error(w, "file not found", http.StatusNotFound)
 			return
@@ -374,3 +657,15 @@ func (t *Thread) ExportDebugHandlers(mux *http.ServeMux) {
 		}
 	})
 }
+
+// findFile looks up a blockfile by name across all of this thread's tiers.
+//
+// This method should only be called once the t.mu has been acquired!
+func (t *Thread) findFile(name string) *blockfile.BlockFile {
+	for _, tr := range t.tiers {
+		if fh := tr.files[name]; fh != nil {
+			return fh.bf
+		}
+	}
+	return nil
+}